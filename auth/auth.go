@@ -0,0 +1,158 @@
+// Package auth tracks bans by IP address, nickname pattern, and SSH
+// fingerprint, with optional expiry, and persists them to a JSON file so
+// they survive restarts.
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Scope string
+
+const (
+	ScopeIP          Scope = "ip"
+	ScopeNick        Scope = "nick"
+	ScopeFingerprint Scope = "fingerprint"
+)
+
+// ParseScope validates a scope name typed at the /ban command.
+func ParseScope(s string) (Scope, error) {
+	switch Scope(s) {
+	case ScopeIP, ScopeNick, ScopeFingerprint:
+		return Scope(s), nil
+	default:
+		return "", fmt.Errorf("unknown ban scope %q (want ip, nick, or fingerprint)", s)
+	}
+}
+
+type Ban struct {
+	Scope     Scope     `json:"scope"`
+	Value     string    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+func (b Ban) Expired(now time.Time) bool {
+	return !b.ExpiresAt.IsZero() && now.After(b.ExpiresAt)
+}
+
+// Matches reports whether value falls under this ban. Nick bans match as a
+// case-insensitive prefix so one ban can cover a family of nicknames.
+func (b Ban) Matches(value string) bool {
+	if b.Scope == ScopeNick {
+		return strings.HasPrefix(strings.ToLower(value), strings.ToLower(b.Value))
+	}
+	return b.Value == value
+}
+
+// Auth is the in-memory ban list backing a single JSON file on disk.
+type Auth struct {
+	mu   sync.Mutex
+	path string
+	bans []Ban
+}
+
+// New loads the ban list at path, if it exists, and returns an Auth backed
+// by it. A missing file is not an error; it means no bans yet.
+func New(path string) (*Auth, error) {
+	a := &Auth{path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return a, nil
+		}
+		return nil, fmt.Errorf("reading ban store: %w", err)
+	}
+	if err := json.Unmarshal(data, &a.bans); err != nil {
+		return nil, fmt.Errorf("parsing ban store: %w", err)
+	}
+	return a, nil
+}
+
+// Ban adds a new ban for value under scope. A zero duration bans forever.
+func (a *Auth) Ban(scope Scope, value string, duration time.Duration) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ban := Ban{Scope: scope, Value: value}
+	if duration > 0 {
+		ban.ExpiresAt = time.Now().Add(duration)
+	}
+	a.bans = append(a.bans, ban)
+	return a.saveLocked()
+}
+
+// Unban removes every ban exactly matching scope and value, reporting
+// whether anything was removed.
+func (a *Auth) Unban(scope Scope, value string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	removed := false
+	kept := a.bans[:0]
+	for _, b := range a.bans {
+		if b.Scope == scope && b.Value == value {
+			removed = true
+			continue
+		}
+		kept = append(kept, b)
+	}
+	a.bans = kept
+	if !removed {
+		return false, nil
+	}
+	return true, a.saveLocked()
+}
+
+// Check reports the first non-expired ban matching value under scope, if
+// any, pruning expired entries from memory as it goes.
+func (a *Auth) Check(scope Scope, value string) (Ban, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pruneExpiredLocked()
+	for _, b := range a.bans {
+		if b.Scope == scope && b.Matches(value) {
+			return b, true
+		}
+	}
+	return Ban{}, false
+}
+
+// List returns every active (non-expired) ban.
+func (a *Auth) List() []Ban {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.pruneExpiredLocked()
+	out := make([]Ban, len(a.bans))
+	copy(out, a.bans)
+	return out
+}
+
+func (a *Auth) pruneExpiredLocked() {
+	now := time.Now()
+	kept := a.bans[:0]
+	for _, b := range a.bans {
+		if !b.Expired(now) {
+			kept = append(kept, b)
+		}
+	}
+	a.bans = kept
+}
+
+func (a *Auth) saveLocked() error {
+	data, err := json.MarshalIndent(a.bans, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding ban store: %w", err)
+	}
+	if err := os.WriteFile(a.path, data, 0644); err != nil {
+		return fmt.Errorf("writing ban store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestAuth(t *testing.T) *Auth {
+	t.Helper()
+	a, err := New(filepath.Join(t.TempDir(), "bans.json"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return a
+}
+
+func TestParseScope(t *testing.T) {
+	for _, s := range []string{"ip", "nick", "fingerprint"} {
+		if _, err := ParseScope(s); err != nil {
+			t.Errorf("ParseScope(%q): unexpected error: %v", s, err)
+		}
+	}
+	if _, err := ParseScope("bogus"); err == nil {
+		t.Error("ParseScope(\"bogus\"): expected error, got nil")
+	}
+}
+
+func TestBanAndCheck(t *testing.T) {
+	a := newTestAuth(t)
+
+	if err := a.Ban(ScopeIP, "1.2.3.4", 0); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+
+	if _, banned := a.Check(ScopeIP, "1.2.3.4"); !banned {
+		t.Error("Check(1.2.3.4): expected banned, got not banned")
+	}
+	if _, banned := a.Check(ScopeIP, "5.6.7.8"); banned {
+		t.Error("Check(5.6.7.8): expected not banned, got banned")
+	}
+	if _, banned := a.Check(ScopeNick, "1.2.3.4"); banned {
+		t.Error("Check under the wrong scope matched a ban from a different scope")
+	}
+}
+
+func TestBanNickMatchesByPrefix(t *testing.T) {
+	a := newTestAuth(t)
+	if err := a.Ban(ScopeNick, "spam", 0); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+
+	if _, banned := a.Check(ScopeNick, "spammer99"); !banned {
+		t.Error("Check(spammer99): expected the \"spam\" nick ban to match as a prefix")
+	}
+	if _, banned := a.Check(ScopeNick, "SPAMBOT"); !banned {
+		t.Error("Check(SPAMBOT): expected nick ban matching to be case-insensitive")
+	}
+	if _, banned := a.Check(ScopeNick, "notit"); banned {
+		t.Error("Check(notit): expected no match for an unrelated nick")
+	}
+}
+
+func TestBanExpiry(t *testing.T) {
+	a := newTestAuth(t)
+	if err := a.Ban(ScopeNick, "temp", 20*time.Millisecond); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+
+	if _, banned := a.Check(ScopeNick, "temp"); !banned {
+		t.Fatal("Check(temp): expected banned immediately after Ban")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if _, banned := a.Check(ScopeNick, "temp"); banned {
+		t.Error("Check(temp): expected the ban to have expired")
+	}
+	for _, b := range a.List() {
+		if b.Value == "temp" {
+			t.Error("List: expired ban was not pruned")
+		}
+	}
+}
+
+func TestUnban(t *testing.T) {
+	a := newTestAuth(t)
+	if err := a.Ban(ScopeFingerprint, "SHA256:abc", 0); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+
+	removed, err := a.Unban(ScopeFingerprint, "SHA256:abc")
+	if err != nil {
+		t.Fatalf("Unban: %v", err)
+	}
+	if !removed {
+		t.Error("Unban: expected removed=true for an existing ban")
+	}
+	if _, banned := a.Check(ScopeFingerprint, "SHA256:abc"); banned {
+		t.Error("Check: expected the ban to be gone after Unban")
+	}
+
+	removed, err = a.Unban(ScopeFingerprint, "SHA256:abc")
+	if err != nil {
+		t.Fatalf("Unban: %v", err)
+	}
+	if removed {
+		t.Error("Unban: expected removed=false for an already-removed ban")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sportshead/mediumchat/auth"
+)
+
+// handleBan implements "/ban <ip|nick|fingerprint> <value> [duration]",
+// restricted to admins. duration is parsed with time.ParseDuration; omitting
+// it bans forever.
+func handleBan(authStore *auth.Auth, client *Client, input string) {
+	if !client.IsAdmin {
+		writeClient(fmt.Sprintf("%s Permission denied.\n", ServerPrefix), client)
+		return
+	}
+
+	fields := strings.Fields(input)
+	if len(fields) < 3 {
+		writeClient(fmt.Sprintf("%s Usage: /ban <ip|nick|fingerprint> <value> [duration]\n", ServerPrefix), client)
+		return
+	}
+	scope, err := auth.ParseScope(fields[1])
+	if err != nil {
+		writeClient(fmt.Sprintf("%s %s\n", ServerPrefix, err), client)
+		return
+	}
+	value := fields[2]
+
+	var duration time.Duration
+	if len(fields) >= 4 {
+		duration, err = time.ParseDuration(fields[3])
+		if err != nil {
+			writeClient(fmt.Sprintf("%s invalid duration %q: %s\n", ServerPrefix, fields[3], err), client)
+			return
+		}
+	}
+
+	if err := authStore.Ban(scope, value, duration); err != nil {
+		writeClient(fmt.Sprintf("%s Failed to save ban: %s\n", ServerPrefix, err), client)
+		return
+	}
+	writeClient(fmt.Sprintf("%s Banned %s %s.\n", ServerPrefix, scope, value), client)
+}
+
+// handleUnban implements "/unban <ip|nick|fingerprint> <value>".
+func handleUnban(authStore *auth.Auth, client *Client, input string) {
+	if !client.IsAdmin {
+		writeClient(fmt.Sprintf("%s Permission denied.\n", ServerPrefix), client)
+		return
+	}
+
+	fields := strings.Fields(input)
+	if len(fields) < 3 {
+		writeClient(fmt.Sprintf("%s Usage: /unban <ip|nick|fingerprint> <value>\n", ServerPrefix), client)
+		return
+	}
+	scope, err := auth.ParseScope(fields[1])
+	if err != nil {
+		writeClient(fmt.Sprintf("%s %s\n", ServerPrefix, err), client)
+		return
+	}
+
+	removed, err := authStore.Unban(scope, fields[2])
+	if err != nil {
+		writeClient(fmt.Sprintf("%s Failed to save ban store: %s\n", ServerPrefix, err), client)
+		return
+	}
+	if !removed {
+		writeClient(fmt.Sprintf("%s No matching ban found.\n", ServerPrefix), client)
+		return
+	}
+	writeClient(fmt.Sprintf("%s Unbanned %s %s.\n", ServerPrefix, scope, fields[2]), client)
+}
+
+// handleBanned implements "/banned", listing active bans grouped by scope.
+func handleBanned(authStore *auth.Auth, client *Client) {
+	if !client.IsAdmin {
+		writeClient(fmt.Sprintf("%s Permission denied.\n", ServerPrefix), client)
+		return
+	}
+
+	grouped := map[auth.Scope][]auth.Ban{}
+	for _, b := range authStore.List() {
+		grouped[b.Scope] = append(grouped[b.Scope], b)
+	}
+	if len(grouped) == 0 {
+		writeClient(fmt.Sprintf("%s No active bans.\n", ServerPrefix), client)
+		return
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s Active bans:\n", ServerPrefix)
+	for _, scope := range []auth.Scope{auth.ScopeIP, auth.ScopeNick, auth.ScopeFingerprint} {
+		for _, b := range grouped[scope] {
+			expiry := "never"
+			if !b.ExpiresAt.IsZero() {
+				expiry = b.ExpiresAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(&sb, "%s   - [%s] %s (expires %s)\n", ServerPrefix, scope, b.Value, expiry)
+		}
+	}
+	writeClient(sb.String(), client)
+}
+
+// handleKick implements "/kick <nick>", disconnecting the named client.
+func handleKick(hub *Hub, client *Client, input string) {
+	if !client.IsAdmin {
+		writeClient(fmt.Sprintf("%s Permission denied.\n", ServerPrefix), client)
+		return
+	}
+
+	fields := strings.Fields(input)
+	if len(fields) < 2 {
+		writeClient(fmt.Sprintf("%s Usage: /kick <nick>\n", ServerPrefix), client)
+		return
+	}
+
+	result := make(chan error, 1)
+	hub.kick <- kickRequest{nick: fields[1], result: result}
+	if err := <-result; err != nil {
+		writeClient(fmt.Sprintf("%s %s\n", ServerPrefix, err), client)
+	}
+}
+
+// handleJoin implements "/join #room", switching the client's current room.
+// It returns the room the client ends up in, for the caller to remember.
+func handleJoin(hub *Hub, client *Client, room, input string) string {
+	fields := strings.Fields(input)
+	if len(fields) < 2 {
+		writeClient(fmt.Sprintf("%s Usage: /join #room\n", ServerPrefix), client)
+		return room
+	}
+	target := fields[1]
+	if !strings.HasPrefix(target, "#") {
+		writeClient(fmt.Sprintf("%s Room names must start with #.\n", ServerPrefix), client)
+		return room
+	}
+
+	result := make(chan error, 1)
+	hub.join <- joinRequest{clientId: client.Id, room: target, result: result}
+	if err := <-result; err != nil {
+		writeClient(fmt.Sprintf("%s %s\n", ServerPrefix, err), client)
+		return room
+	}
+	// doBroadcast skips the acting client, so confirm the join directly
+	// rather than relying on the room's broadcast to reach them.
+	writeClient(fmt.Sprintf("%s You joined %s.\n", ServerPrefix, target), client)
+	return target
+}
+
+// handleLeave implements "/leave [#room]", defaulting to the client's
+// current room, and returns DefaultRoom on success since that's always
+// where a client lands after leaving.
+func handleLeave(hub *Hub, client *Client, room, input string) string {
+	target := room
+	if fields := strings.Fields(input); len(fields) > 1 {
+		target = fields[1]
+	}
+
+	result := make(chan error, 1)
+	hub.leave <- leaveRequest{clientId: client.Id, room: target, result: result}
+	if err := <-result; err != nil {
+		writeClient(fmt.Sprintf("%s %s\n", ServerPrefix, err), client)
+		return room
+	}
+	// The client is no longer a member of target by the time handleJoin's
+	// broadcast for it goes out, so confirm the leave directly.
+	writeClient(fmt.Sprintf("%s You left %s.\n", ServerPrefix, target), client)
+	return DefaultRoom
+}
+
+// handleTopic implements "/topic #room [text]": with no text it reports
+// the room's current topic, otherwise it sets it.
+func handleTopic(hub *Hub, client *Client, room, input string) {
+	fields := strings.SplitN(input, " ", 3)
+	if len(fields) < 2 || fields[1] == "" {
+		writeClient(fmt.Sprintf("%s Usage: /topic #room [text]\n", ServerPrefix), client)
+		return
+	}
+	target := fields[1]
+	query := len(fields) < 3
+	var topicText string
+	if !query {
+		topicText = fields[2]
+	}
+
+	result := make(chan topicResult, 1)
+	hub.topic <- topicRequest{clientId: client.Id, room: target, topic: topicText, query: query, result: result}
+	res := <-result
+	if res.err != nil {
+		writeClient(fmt.Sprintf("%s %s\n", ServerPrefix, res.err), client)
+		return
+	}
+	if query {
+		topic := res.topic
+		if topic == "" {
+			topic = "(no topic)"
+		}
+		writeClient(fmt.Sprintf("%s Topic for %s: %s\n", ServerPrefix, target, topic), client)
+		return
+	}
+	// doBroadcast skips the acting client, so confirm the change directly
+	// rather than relying on the room's broadcast to reach them.
+	writeClient(fmt.Sprintf("%s Topic for %s set to: %s\n", ServerPrefix, target, res.topic), client)
+}
+
+// handleMsg implements "/msg <nick> <text>", a private message delivered
+// straight to the target client without touching any room.
+func handleMsg(hub *Hub, client *Client, name, input string) {
+	fields := strings.SplitN(input, " ", 3)
+	if len(fields) < 3 {
+		writeClient(fmt.Sprintf("%s Usage: /msg <nick> <text>\n", ServerPrefix), client)
+		return
+	}
+
+	result := make(chan error, 1)
+	hub.direct <- directRequest{fromId: client.Id, toNick: fields[1], text: fields[2], result: result}
+	if err := <-result; err != nil {
+		writeClient(fmt.Sprintf("%s %s\n", ServerPrefix, err), client)
+	}
+}
+
+// handleMe implements "/me <action>", broadcasting an action line to the
+// client's current room.
+func handleMe(hub *Hub, client *Client, name, room, input string) {
+	action := strings.TrimSpace(strings.TrimPrefix(input, "/me"))
+	if action == "" {
+		return
+	}
+	hub.broadcast <- Message{
+		Sender:     client.Id,
+		SenderName: name,
+		Target:     room,
+		Kind:       MessageChat,
+		Text:       fmt.Sprintf("* %s %s", name, action),
+		PlainText:  fmt.Sprintf("%s* %s %s\n", ServerPrefix, name, action),
+	}
+}
+
+// handleWho implements "/who [#room]", listing the members of room (the
+// client's current room if omitted).
+func handleWho(hub *Hub, client *Client, room, input string) {
+	target := room
+	if fields := strings.Fields(input); len(fields) > 1 {
+		target = fields[1]
+	}
+
+	result := make(chan []string, 1)
+	hub.list <- listRequest{room: target, result: result}
+	names := <-result
+	writeClient(fmt.Sprintf("%s Users in %s: %s\n", ServerPrefix, target, strings.Join(names, ", ")), client)
+}
+
+// handleList implements "/list", summarizing every room on the server.
+func handleList(hub *Hub, client *Client) {
+	result := make(chan []roomSummary, 1)
+	hub.listRooms <- listRoomsRequest{result: result}
+	rooms := <-result
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s Rooms:\n", ServerPrefix)
+	for _, r := range rooms {
+		topic := r.Topic
+		if topic == "" {
+			topic = "(no topic)"
+		}
+		fmt.Fprintf(&sb, "%s   - %s (%d users): %s\n", ServerPrefix, r.Name, r.Members, topic)
+	}
+	writeClient(sb.String(), client)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sportshead/mediumchat/auth"
+)
+
+const ircServerName = "mediumchat"
+
+// ircMessage is a parsed IRC line: an optional prefix, a command, and its
+// parameters (with the trailing ":"-prefixed parameter, if any, unquoted).
+type ircMessage struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// parseIRCLine parses a subset of the RFC 1459/2812 message grammar
+// (prefix, command, middle params, trailing param). It returns ok=false
+// for blank or malformed lines, which callers should simply ignore.
+func parseIRCLine(line string) (ircMessage, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return ircMessage{}, false
+	}
+
+	var msg ircMessage
+	if strings.HasPrefix(line, ":") {
+		idx := strings.IndexByte(line, ' ')
+		if idx < 0 {
+			return ircMessage{}, false
+		}
+		msg.Prefix = line[1:idx]
+		line = line[idx+1:]
+	}
+
+	if idx := strings.Index(line, " :"); idx >= 0 {
+		fields := strings.Fields(line[:idx])
+		if len(fields) == 0 {
+			return ircMessage{}, false
+		}
+		msg.Command = strings.ToUpper(fields[0])
+		msg.Params = append(fields[1:], line[idx+2:])
+		return msg, true
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ircMessage{}, false
+	}
+	msg.Command = strings.ToUpper(fields[0])
+	msg.Params = fields[1:]
+	return msg, true
+}
+
+// ircLine formats a server-to-client IRC message. The last parameter is
+// sent as a trailing (":"-prefixed) parameter if it contains a space or is
+// empty, per the usual IRC convention.
+func ircLine(prefix, command string, params ...string) string {
+	var sb strings.Builder
+	if prefix != "" {
+		sb.WriteByte(':')
+		sb.WriteString(prefix)
+		sb.WriteByte(' ')
+	}
+	sb.WriteString(command)
+	for i, p := range params {
+		sb.WriteByte(' ')
+		if i == len(params)-1 && (p == "" || strings.ContainsRune(p, ' ')) {
+			sb.WriteByte(':')
+		}
+		sb.WriteString(p)
+	}
+	sb.WriteString("\r\n")
+	return sb.String()
+}
+
+func ircPrefix(nick string) string {
+	return fmt.Sprintf("%s!%s@mediumchat", nick, nick)
+}
+
+// renderIRCMessage turns a hub broadcast Message into the IRC line its
+// recipients expect, so IRC clients see the same events as everyone else
+// without raw-TCP/SSH clients having to know anything about IRC.
+func renderIRCMessage(msg Message) string {
+	switch msg.Kind {
+	case MessageChat:
+		// Target is the room for ordinary chat, or the recipient's own nick
+		// for a /msg private message; either way it's the right PRIVMSG target.
+		return ircLine(ircPrefix(msg.SenderName), "PRIVMSG", msg.Target, msg.Text)
+	case MessageJoin:
+		return ircLine(ircPrefix(msg.SenderName), "JOIN", DefaultRoom)
+	case MessagePart:
+		return ircLine(ircPrefix(msg.SenderName), "QUIT", msg.Text)
+	case MessageLeave:
+		return ircLine(ircPrefix(msg.SenderName), "PART", msg.Target)
+	case MessageNick:
+		return ircLine(ircPrefix(msg.SenderName), "NICK", msg.Text)
+	case MessageServer:
+		return ircLine(ircServerName, "NOTICE", DefaultRoom, msg.Text)
+	default:
+		return msg.PlainText
+	}
+}
+
+// runIRCServer listens for plain-text IRC connections, speaking the subset
+// of RFC 1459/2812 needed for NICK/USER registration and basic chat against
+// DefaultRoom.
+func runIRCServer(ctx context.Context, addr string, hub *Hub, authStore *auth.Auth, grace time.Duration) int {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("failed to create irc listener", slogTag("irc_listen_failed"), slogError(err))
+		return 1
+	}
+	slog.Info("irc server listening", slogTag("irc_listening"), slog.String("addr", addr))
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return 0
+			default:
+				slog.Error("failed to accept irc connection", slogTag("irc_accept_failed"), slogError(err))
+				return 1
+			}
+		}
+
+		if ip, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+			if ban, banned := authStore.Check(auth.ScopeIP, ip); banned {
+				slog.Info("rejected banned ip", slogTag("ip_banned"), slog.String("ip", ip), slog.Any("ban", ban))
+				conn.Close()
+				continue
+			}
+		}
+		go handleIRCConn(ctx, conn, hub, authStore, grace)
+	}
+}
+
+// handleIRCConn performs the NICK/USER registration handshake and then
+// drives the connection like any other client, translating IRC commands
+// onto the same hub operations /nick and /disconnect use.
+func handleIRCConn(ctx context.Context, conn net.Conn, hub *Hub, authStore *auth.Auth, grace time.Duration) {
+	scanner := bufio.NewScanner(conn)
+
+	var nick, user string
+	for nick == "" || user == "" {
+		if !scanner.Scan() {
+			conn.Close()
+			return
+		}
+		msg, ok := parseIRCLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		switch msg.Command {
+		case "NICK":
+			if len(msg.Params) > 0 {
+				nick = msg.Params[0]
+			}
+		case "USER":
+			if len(msg.Params) > 0 {
+				user = msg.Params[0]
+			}
+		case "QUIT":
+			conn.Close()
+			return
+		}
+	}
+
+	client := &Client{
+		Id:     newClientId(),
+		Conn:   conn,
+		Send:   make(chan string, sendBufferSize),
+		Render: renderIRCMessage,
+	}
+	ircHandleClient(ctx, hub, authStore, client, nick, scanner, grace)
+}
+
+// ircHandleClient is the IRC equivalent of handleClient: it registers with
+// the hub, welcomes the client, and dispatches incoming IRC commands for
+// the lifetime of the connection.
+func ircHandleClient(ctx context.Context, hub *Hub, authStore *auth.Auth, client *Client, name string, scanner *bufio.Scanner, grace time.Duration) {
+	logger := slog.With(slog.Int("client", client.Id))
+	logger.Info("client connected", slogTag("client_connected"))
+
+	go clientWriter(client)
+
+	done := make(chan struct{})
+	defer close(done)
+	go watchForShutdown(ctx, done, grace, client.Conn)
+
+	if _, banned := authStore.Check(auth.ScopeNick, name); banned {
+		writeClient(ircLine(ircServerName, "ERROR", fmt.Sprintf("Nickname %s is banned", name)), client)
+		close(client.Send)
+		return
+	}
+	if forbiddenNickname(client.Id, name) {
+		writeClient(ircLine(ircServerName, "ERROR", fmt.Sprintf("Nickname %s is forbidden", name)), client)
+		close(client.Send)
+		return
+	}
+
+	result := make(chan error, 1)
+	hub.register <- registerRequest{client: client, name: name, result: result}
+	if err := <-result; err != nil {
+		writeClient(ircLine(ircServerName, "433", "*", name, "Nickname is already in use"), client)
+		close(client.Send)
+		return
+	}
+
+	writeClient(ircWelcome(hub, name), client)
+	hub.broadcast <- Message{
+		Sender:     client.Id,
+		SenderName: name,
+		Target:     DefaultRoom,
+		Kind:       MessageJoin,
+		PlainText:  fmt.Sprintf("%s %s joined.\n", ServerPrefix, name),
+	}
+
+	for scanner.Scan() {
+		msg, ok := parseIRCLine(scanner.Text())
+		if !ok {
+			continue
+		}
+
+		switch msg.Command {
+		case "NICK":
+			if len(msg.Params) > 0 {
+				name = ircHandleNick(hub, authStore, client, name, msg.Params[0])
+			}
+		case "PRIVMSG", "NOTICE":
+			if len(msg.Params) < 2 {
+				continue
+			}
+			target := msg.Params[0]
+			if target == DefaultRoom {
+				hub.broadcast <- Message{
+					Sender:     client.Id,
+					SenderName: name,
+					Target:     DefaultRoom,
+					Kind:       MessageChat,
+					Text:       msg.Params[1],
+					PlainText:  fmt.Sprintf("%s> %s\n", name, msg.Params[1]),
+				}
+				continue
+			}
+			if strings.HasPrefix(target, "#") {
+				writeClient(ircLine(ircServerName, "403", name, target, "No such channel"), client)
+				continue
+			}
+			// Not a channel: treat target as a nick, same as /msg.
+			result := make(chan error, 1)
+			hub.direct <- directRequest{fromId: client.Id, toNick: target, text: msg.Params[1], result: result}
+			if err := <-result; err != nil {
+				writeClient(ircLine(ircServerName, "401", name, target, "No such nick"), client)
+			}
+		case "JOIN", "NAMES":
+			if len(msg.Params) > 0 && msg.Params[0] != DefaultRoom && msg.Command == "JOIN" {
+				writeClient(ircLine(ircServerName, "403", name, msg.Params[0], "No such channel"), client)
+				continue
+			}
+			writeClient(ircNamesReply(hub, name), client)
+		case "PART":
+			// Single-room server: nothing to leave, so PART is ignored and
+			// no reply is sent.
+		case "WHO":
+			writeClient(ircWhoReply(hub, name), client)
+		case "PING":
+			token := ircServerName
+			if len(msg.Params) > 0 {
+				token = msg.Params[0]
+			}
+			writeClient(ircLine("", "PONG", ircServerName, token), client)
+		case "QUIT":
+			reason := "leaving"
+			if len(msg.Params) > 0 {
+				reason = msg.Params[0]
+			}
+			client.Conn.Close()
+			hub.broadcast <- Message{
+				Sender:     client.Id,
+				SenderName: name,
+				Kind:       MessagePart,
+				Text:       reason,
+				PlainText:  fmt.Sprintf("%s %s disconnected.\n", ServerPrefix, name),
+			}
+		}
+	}
+
+	hub.unregister <- unregisterRequest{clientId: client.Id}
+	if err := scanner.Err(); err != nil {
+		logger.Error("failed to read from client", slogTag("read_client_failed"), slogError(err))
+	}
+}
+
+// ircHandleNick is the IRC counterpart to handleNick: same hub.rename
+// round-trip, but replies with the numerics an IRC client expects.
+func ircHandleNick(hub *Hub, authStore *auth.Auth, client *Client, name, newName string) string {
+	if _, banned := authStore.Check(auth.ScopeNick, newName); banned {
+		writeClient(ircLine(ircServerName, "432", name, newName, "Erroneous nickname"), client)
+		return name
+	}
+	if forbiddenNickname(client.Id, newName) {
+		writeClient(ircLine(ircServerName, "432", name, newName, "Erroneous nickname"), client)
+		return name
+	}
+
+	result := make(chan error, 1)
+	hub.rename <- renameRequest{clientId: client.Id, newName: newName, result: result}
+	if err := <-result; err != nil {
+		writeClient(ircLine(ircServerName, "433", name, newName, "Nickname is already in use"), client)
+		return name
+	}
+	return newName
+}
+
+// ircWelcome sends the registration numerics plus an implicit JOIN and
+// NAMES reply for DefaultRoom, since every IRC client lands there.
+func ircWelcome(hub *Hub, nick string) string {
+	var sb strings.Builder
+	sb.WriteString(ircLine(ircServerName, "001", nick, fmt.Sprintf("Welcome to MediumChat, %s", nick)))
+	sb.WriteString(ircLine(ircServerName, "002", nick, fmt.Sprintf("Your host is %s", ircServerName)))
+	sb.WriteString(ircLine(ircServerName, "003", nick, "This server has no creation date"))
+	sb.WriteString(ircLine(ircServerName, "004", nick, ircServerName, "0"))
+	sb.WriteString(ircLine(ircPrefix(nick), "JOIN", DefaultRoom))
+	sb.WriteString(ircNamesReply(hub, nick))
+	return sb.String()
+}
+
+func ircNamesReply(hub *Hub, nick string) string {
+	result := make(chan []string, 1)
+	hub.list <- listRequest{room: DefaultRoom, result: result}
+	names := <-result
+
+	var sb strings.Builder
+	sb.WriteString(ircLine(ircServerName, "353", nick, "=", DefaultRoom, strings.Join(names, " ")))
+	sb.WriteString(ircLine(ircServerName, "366", nick, DefaultRoom, "End of /NAMES list"))
+	return sb.String()
+}
+
+func ircWhoReply(hub *Hub, nick string) string {
+	result := make(chan []string, 1)
+	hub.list <- listRequest{room: DefaultRoom, result: result}
+	names := <-result
+
+	var sb strings.Builder
+	for _, n := range names {
+		sb.WriteString(ircLine(ircServerName, "352", nick, DefaultRoom, n, "mediumchat", ircServerName, n, "H", "0 "+n))
+	}
+	sb.WriteString(ircLine(ircServerName, "315", nick, DefaultRoom, "End of /WHO list"))
+	return sb.String()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIRCLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want ircMessage
+		ok   bool
+	}{
+		{
+			name: "simple command",
+			line: "PING",
+			want: ircMessage{Command: "PING", Params: []string{}},
+			ok:   true,
+		},
+		{
+			name: "command with middle params",
+			line: "NICK bob",
+			want: ircMessage{Command: "NICK", Params: []string{"bob"}},
+			ok:   true,
+		},
+		{
+			name: "trailing param with spaces",
+			line: "PRIVMSG #general :hi there",
+			want: ircMessage{Command: "PRIVMSG", Params: []string{"#general", "hi there"}},
+			ok:   true,
+		},
+		{
+			name: "prefix, command, and trailing param",
+			line: ":bob!bob@mediumchat PRIVMSG #general :hi there",
+			want: ircMessage{Prefix: "bob!bob@mediumchat", Command: "PRIVMSG", Params: []string{"#general", "hi there"}},
+			ok:   true,
+		},
+		{
+			name: "command is upper-cased",
+			line: "nick bob",
+			want: ircMessage{Command: "NICK", Params: []string{"bob"}},
+			ok:   true,
+		},
+		{
+			name: "trailing carriage return and newline are stripped",
+			line: "PING\r\n",
+			want: ircMessage{Command: "PING", Params: []string{}},
+			ok:   true,
+		},
+		{
+			name: "empty line",
+			line: "",
+			ok:   false,
+		},
+		{
+			name: "prefix with no following command",
+			line: ":bob",
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseIRCLine(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("parseIRCLine(%q) ok = %v, want %v", tt.line, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseIRCLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIrcLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		command string
+		params  []string
+		want    string
+	}{
+		{
+			name:    "no prefix, no params",
+			command: "PING",
+			want:    "PING\r\n",
+		},
+		{
+			name:    "prefix and simple param",
+			prefix:  "mediumchat",
+			command: "001",
+			params:  []string{"bob", "hi there"},
+			want:    ":mediumchat 001 bob :hi there\r\n",
+		},
+		{
+			name:    "last param with no space isn't quoted",
+			command: "JOIN",
+			params:  []string{"#general"},
+			want:    "JOIN #general\r\n",
+		},
+		{
+			name:    "empty last param is quoted",
+			command: "PART",
+			params:  []string{"#general", ""},
+			want:    "PART #general :\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ircLine(tt.prefix, tt.command, tt.params...); got != tt.want {
+				t.Errorf("ircLine(%q, %q, %v) = %q, want %q", tt.prefix, tt.command, tt.params, got, tt.want)
+			}
+		})
+	}
+}
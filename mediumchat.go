@@ -2,59 +2,609 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
+
+	"github.com/sportshead/mediumchat/auth"
 )
 
+// sendBufferSize bounds each client's outbound queue. A client that can't
+// keep up with the buffer is dropped rather than stalling the hub.
+const sendBufferSize = 16
+
+// Conn is the minimal connection interface handleClient needs. It is
+// satisfied by net.Conn as well as an ssh.Channel, so the same client loop
+// can drive both the raw-TCP and SSH listeners.
+type Conn interface {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
 type Client struct {
-	Id   int
-	Name string
-	Conn net.Conn
+	Id          int
+	Conn        Conn
+	Send        chan string
+	Fingerprint string
+	IsAdmin     bool
+
+	// Render formats a broadcast Message for this client's protocol. If nil,
+	// msg.PlainText is sent as-is (the raw-TCP and SSH behavior).
+	Render func(Message) string
+}
+
+// slogTag attaches a stable, greppable event name to a log line, since
+// slog's free-text messages vary in wording but call sites key off this.
+func slogTag(tag string) slog.Attr {
+	return slog.String("tag", tag)
+}
+
+// slogError renders an error for a log line the same way everywhere.
+func slogError(err error) slog.Attr {
+	return slog.String("error", err.Error())
+}
+
+// nextClientId is shared by every listener so client IDs stay unique
+// regardless of which transport they arrived on.
+var nextClientId atomic.Int64
+
+func newClientId() int {
+	return int(nextClientId.Add(1))
 }
 
-var clients = make(map[int]Client)
-var names = make(map[string]struct{})
+type MessageKind int
 
+const (
+	MessageChat MessageKind = iota
+	MessageJoin
+	MessagePart
+	MessageLeave
+	MessageNick
+	MessageServer
+)
+
+// Message is a broadcast event. PlainText is the fully rendered form used
+// by raw-TCP and SSH clients; Kind/SenderName/Text let other protocols (see
+// Client.Render) render their own representation of the same event.
+//
+// Target names the recipients: a room name (e.g. "#general") scopes the
+// message to that room's members, and "" means every connected client
+// (used for server notices, nickname changes, and kicks). Direct messages
+// bypass Target/doBroadcast entirely; see Hub.handleDirect.
 type Message struct {
-	Sender  int
-	Message string
+	Sender     int
+	SenderName string
+	Kind       MessageKind
+	Target     string
+	Text       string
+	PlainText  string
+}
+
+// Room is a named chat room. Hub owns the only copy of each Room and
+// mutates it from its own goroutine, same as the rest of its state.
+type Room struct {
+	Name    string
+	Topic   string
+	Members map[int]bool
+}
+
+func newRoom(name string) *Room {
+	return &Room{Name: name, Members: make(map[int]bool)}
+}
+
+// DefaultRoom is where every client lands on connect; raw-TCP and SSH
+// clients can /join others, but IRC clients (see irc.go) only ever know
+// about this one.
+const DefaultRoom = "#general"
+
+type registerRequest struct {
+	client *Client
+	name   string
+	result chan error
+}
+
+type unregisterRequest struct {
+	clientId int
+}
+
+type renameRequest struct {
+	clientId int
+	newName  string
+	result   chan error
+}
+
+type kickRequest struct {
+	nick   string
+	result chan error
+}
+
+// listRequest asks for the member names of room, or of every connected
+// client if room is "".
+type listRequest struct {
+	room   string
+	result chan []string
+}
+
+type joinRequest struct {
+	clientId int
+	room     string
+	result   chan error
+}
+
+type leaveRequest struct {
+	clientId int
+	room     string
+	result   chan error
+}
+
+type topicRequest struct {
+	clientId int
+	room     string
+	topic    string
+	query    bool
+	result   chan topicResult
+}
+
+type topicResult struct {
+	topic string
+	err   error
+}
+
+type directRequest struct {
+	fromId int
+	toNick string
+	text   string
+	result chan error
+}
+
+type roomSummary struct {
+	Name    string
+	Topic   string
+	Members int
+}
+
+type listRoomsRequest struct {
+	result chan []roomSummary
+}
+
+// Hub owns all shared chat state (connected clients, their names, and the
+// rooms they belong to) and serializes access to it through a single
+// goroutine. Callers interact with it exclusively via its channels.
+type Hub struct {
+	clients     map[int]*Client
+	names       map[string]int
+	clientNames map[int]string
+	rooms       map[string]*Room
+	clientRoom  map[int]string
+
+	register   chan registerRequest
+	unregister chan unregisterRequest
+	rename     chan renameRequest
+	kick       chan kickRequest
+	list       chan listRequest
+	join       chan joinRequest
+	leave      chan leaveRequest
+	topic      chan topicRequest
+	direct     chan directRequest
+	listRooms  chan listRoomsRequest
+	broadcast  chan Message
+}
+
+func newHub() *Hub {
+	return &Hub{
+		clients:     make(map[int]*Client),
+		names:       make(map[string]int),
+		clientNames: make(map[int]string),
+		rooms:       map[string]*Room{DefaultRoom: newRoom(DefaultRoom)},
+		clientRoom:  make(map[int]string),
+		register:    make(chan registerRequest),
+		unregister:  make(chan unregisterRequest),
+		rename:      make(chan renameRequest),
+		kick:        make(chan kickRequest),
+		list:        make(chan listRequest),
+		join:        make(chan joinRequest),
+		leave:       make(chan leaveRequest),
+		topic:       make(chan topicRequest),
+		direct:      make(chan directRequest),
+		listRooms:   make(chan listRoomsRequest),
+		broadcast:   make(chan Message),
+	}
+}
+
+func (h *Hub) run(ctx context.Context) {
+	done := ctx.Done()
+	for {
+		select {
+		case <-done:
+			slog.Info("hub stopping", slogTag("hub_stopping"))
+			done = nil
+		case req := <-h.register:
+			h.handleRegister(req)
+		case req := <-h.unregister:
+			h.dropClient(req.clientId)
+		case req := <-h.rename:
+			h.handleRename(req)
+		case req := <-h.kick:
+			h.handleKick(req)
+		case req := <-h.list:
+			req.result <- h.snapshotNames(req.room)
+		case req := <-h.join:
+			h.handleJoin(req)
+		case req := <-h.leave:
+			h.handleLeave(req)
+		case req := <-h.topic:
+			h.handleTopic(req)
+		case req := <-h.direct:
+			h.handleDirect(req)
+		case req := <-h.listRooms:
+			req.result <- h.snapshotRooms()
+		case msg := <-h.broadcast:
+			h.doBroadcast(msg)
+		}
+	}
+}
+
+// snapshotNames returns the nicknames of room's members, sorted for stable
+// output. room == "" returns every connected client's nickname.
+func (h *Hub) snapshotNames(room string) []string {
+	var names []string
+	if room == "" {
+		names = make([]string, 0, len(h.clientNames))
+		for _, name := range h.clientNames {
+			names = append(names, name)
+		}
+	} else if r, ok := h.rooms[room]; ok {
+		names = make([]string, 0, len(r.Members))
+		for id := range r.Members {
+			names = append(names, h.clientNames[id])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// snapshotRooms returns a summary of every room, sorted by name.
+func (h *Hub) snapshotRooms() []roomSummary {
+	summaries := make([]roomSummary, 0, len(h.rooms))
+	for name, room := range h.rooms {
+		summaries = append(summaries, roomSummary{Name: name, Topic: room.Topic, Members: len(room.Members)})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries
+}
+
+// handleRegister admits req.client under req.name, rejecting it if that name
+// is already taken by another connected client. Checking and claiming the
+// name happen atomically in the hub goroutine so two clients racing to
+// register the same name can never both win it, the way an unconditional
+// overwrite would let the loser's entry silently clobber the winner's.
+func (h *Hub) handleRegister(req registerRequest) {
+	if _, taken := h.names[req.name]; taken {
+		req.result <- fmt.Errorf("nickname %s is currently in use", req.name)
+		return
+	}
+	h.clients[req.client.Id] = req.client
+	h.names[req.name] = req.client.Id
+	h.clientNames[req.client.Id] = req.name
+	h.clientRoom[req.client.Id] = DefaultRoom
+	h.rooms[DefaultRoom].Members[req.client.Id] = true
+	req.result <- nil
+}
+
+func (h *Hub) handleRename(req renameRequest) {
+	oldName, ok := h.clientNames[req.clientId]
+	if !ok {
+		return
+	}
+	if _, taken := h.names[req.newName]; taken {
+		req.result <- fmt.Errorf("nickname %s is currently in use", req.newName)
+		return
+	}
+	delete(h.names, oldName)
+	h.names[req.newName] = req.clientId
+	h.clientNames[req.clientId] = req.newName
+	req.result <- nil
+	h.doBroadcast(Message{
+		Sender:     0,
+		SenderName: oldName,
+		Kind:       MessageNick,
+		Text:       req.newName,
+		PlainText:  fmt.Sprintf("%s %s changed their nickname to %s.\n", ServerPrefix, oldName, req.newName),
+	})
+}
+
+func (h *Hub) handleKick(req kickRequest) {
+	id, ok := h.names[req.nick]
+	if !ok {
+		req.result <- fmt.Errorf("no such user %s", req.nick)
+		return
+	}
+	client := h.clients[id]
+	h.dropClient(id)
+	client.Conn.Close()
+	req.result <- nil
+	h.doBroadcast(Message{
+		Sender:     0,
+		SenderName: req.nick,
+		Kind:       MessagePart,
+		Text:       "kicked",
+		PlainText:  fmt.Sprintf("%s %s was kicked.\n", ServerPrefix, req.nick),
+	})
+}
+
+// handleJoin moves a client from its current room into req.room, creating
+// req.room if it doesn't exist yet, and broadcasts a leave/join pair scoped
+// to the old and new rooms respectively. The leave uses MessageLeave, not
+// MessagePart, since the client is switching rooms, not disconnecting.
+func (h *Hub) handleJoin(req joinRequest) {
+	if _, ok := h.clients[req.clientId]; !ok {
+		req.result <- fmt.Errorf("unknown client")
+		return
+	}
+	oldRoom := h.clientRoom[req.clientId]
+	if oldRoom == req.room {
+		req.result <- nil
+		return
+	}
+
+	room, ok := h.rooms[req.room]
+	if !ok {
+		room = newRoom(req.room)
+		h.rooms[req.room] = room
+	}
+
+	name := h.clientNames[req.clientId]
+	if old, ok := h.rooms[oldRoom]; ok {
+		delete(old.Members, req.clientId)
+		h.doBroadcast(Message{
+			Sender:     req.clientId,
+			SenderName: name,
+			Target:     oldRoom,
+			Kind:       MessageLeave,
+			Text:       fmt.Sprintf("left %s", oldRoom),
+			PlainText:  fmt.Sprintf("%s %s left %s.\n", ServerPrefix, name, oldRoom),
+		})
+	}
+
+	room.Members[req.clientId] = true
+	h.clientRoom[req.clientId] = req.room
+	req.result <- nil
+	h.doBroadcast(Message{
+		Sender:     req.clientId,
+		SenderName: name,
+		Target:     req.room,
+		Kind:       MessageJoin,
+		PlainText:  fmt.Sprintf("%s %s joined %s.\n", ServerPrefix, name, req.room),
+	})
+}
+
+// handleLeave moves a client out of req.room and back into DefaultRoom. It
+// fails if the client isn't currently in req.room, or if req.room is
+// DefaultRoom itself (clients always belong to at least that room).
+func (h *Hub) handleLeave(req leaveRequest) {
+	if h.clientRoom[req.clientId] != req.room {
+		req.result <- fmt.Errorf("you are not in %s", req.room)
+		return
+	}
+	if req.room == DefaultRoom {
+		req.result <- fmt.Errorf("cannot leave %s", DefaultRoom)
+		return
+	}
+	result := make(chan error, 1)
+	h.handleJoin(joinRequest{clientId: req.clientId, room: DefaultRoom, result: result})
+	req.result <- <-result
+}
+
+// handleTopic reads or sets req.room's topic. Setting the topic broadcasts
+// a server notice scoped to that room.
+func (h *Hub) handleTopic(req topicRequest) {
+	room, ok := h.rooms[req.room]
+	if !ok {
+		req.result <- topicResult{err: fmt.Errorf("no such room %s", req.room)}
+		return
+	}
+	if req.query {
+		req.result <- topicResult{topic: room.Topic}
+		return
+	}
+
+	room.Topic = req.topic
+	req.result <- topicResult{topic: req.topic}
+	name := h.clientNames[req.clientId]
+	h.doBroadcast(Message{
+		Sender:     req.clientId,
+		SenderName: name,
+		Target:     req.room,
+		Kind:       MessageServer,
+		Text:       fmt.Sprintf("%s changed the topic for %s to: %s", name, req.room, req.topic),
+		PlainText:  fmt.Sprintf("%s %s changed the topic for %s to: %s\n", ServerPrefix, name, req.room, req.topic),
+	})
+}
+
+// handleDirect delivers a private message straight to the target client's
+// send queue, bypassing doBroadcast's room scoping entirely.
+func (h *Hub) handleDirect(req directRequest) {
+	targetId, ok := h.names[req.toNick]
+	if !ok {
+		req.result <- fmt.Errorf("no such user %s", req.toNick)
+		return
+	}
+	target := h.clients[targetId]
+	fromName := h.clientNames[req.fromId]
+
+	msg := Message{
+		Sender:     req.fromId,
+		SenderName: fromName,
+		Target:     req.toNick,
+		Kind:       MessageChat,
+		Text:       req.text,
+		PlainText:  fmt.Sprintf("%s whispers: %s\n", fromName, req.text),
+	}
+	rendered := msg.PlainText
+	if target.Render != nil {
+		rendered = target.Render(msg)
+	}
+
+	select {
+	case target.Send <- rendered:
+		req.result <- nil
+	default:
+		req.result <- fmt.Errorf("could not deliver message to %s (queue full)", req.toNick)
+	}
+}
+
+// doBroadcast delivers msg to every member of msg.Target's room, or to
+// every connected client if Target is "".
+func (h *Hub) doBroadcast(msg Message) {
+	slog.Info("message received",
+		slogTag("new_msg"),
+		slog.Int("from", msg.Sender),
+		slog.String("message", msg.PlainText))
+
+	var members map[int]bool
+	if msg.Target != "" {
+		room, ok := h.rooms[msg.Target]
+		if !ok {
+			return
+		}
+		members = room.Members
+	}
+
+	for id, client := range h.clients {
+		if id == msg.Sender {
+			continue
+		}
+		if members != nil && !members[id] {
+			continue
+		}
+		rendered := msg.PlainText
+		if client.Render != nil {
+			rendered = client.Render(msg)
+		}
+		select {
+		case client.Send <- rendered:
+		default:
+			slog.Warn("client send queue full, disconnecting",
+				slogTag("send_overflow"), slog.Int("client", id))
+			h.dropClient(id)
+			client.Conn.Close()
+		}
+	}
+}
+
+// Notify broadcasts a server notice to every connected client, used for the
+// shutdown warning.
+func (h *Hub) Notify(text string) {
+	h.broadcast <- Message{
+		Sender:    0,
+		Kind:      MessageServer,
+		Text:      text,
+		PlainText: fmt.Sprintf("%s %s\n", ServerPrefix, text),
+	}
+}
+
+// dropClient removes a client from all hub state and closes its send
+// channel. It is a no-op if the client is already gone.
+func (h *Hub) dropClient(id int) {
+	client, ok := h.clients[id]
+	if !ok {
+		return
+	}
+	delete(h.names, h.clientNames[id])
+	delete(h.clientNames, id)
+	if room, ok := h.rooms[h.clientRoom[id]]; ok {
+		delete(room.Members, id)
+	}
+	delete(h.clientRoom, id)
+	delete(h.clients, id)
+	close(client.Send)
 }
 
 const ServerPrefix = "server!"
 const Motd = `%[1]s Welcome to MediumChat.
-%[1]s You are %s.
+%[1]s You are %s, in %s.
 %[1]s Commands:
 %[1]s   - /nick [nick]: Change or reset your nickname
+%[1]s   - /join #room, /leave [#room]: Switch rooms
+%[1]s   - /msg <nick> <text>: Send a private message
+%[1]s   - /me <action>, /who [#room], /list, /topic #room [text]
 %[1]s   - /disconnect: Disconnect from the server
 `
 
 func main() {
 	addr := flag.String("addr", ":4242", "address to listen on")
+	sshAddr := flag.String("ssh-addr", ":2222", "address for the SSH listener to listen on")
+	identity := flag.String("identity", "id_ed25519", "path to the SSH host key (generated on first run if missing)")
+	admin := flag.String("admin", "", "comma-separated SSH key fingerprints granted admin commands")
+	bansPath := flag.String("bans", "bans.json", "path to the ban store")
+	ircAddr := flag.String("irc-addr", ":6667", "address for the IRC-compatible listener to listen on")
+	grace := flag.Duration("grace", 10*time.Second, "how long to let clients drain before a shutdown closes their connections")
 	flag.Parse()
 
+	authStore, err := auth.New(*bansPath)
+	if err != nil {
+		slog.Error("failed to load ban store", slogTag("auth_load_failed"), slogError(err))
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	hub := newHub()
+	go hub.run(ctx)
+
 	go func() {
-		os.Exit(runServer(*addr))
+		if code := runServer(ctx, *addr, hub, authStore, *grace); code != 0 {
+			os.Exit(code)
+		}
 	}()
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		broadcastMessage(Message{
-			Sender:  0,
-			Message: fmt.Sprintf("%s %s\n", ServerPrefix, scanner.Text()),
-		})
-	}
-	if err := scanner.Err(); err != nil {
-		slog.Error("failed to read from stdin", slogTag("read_stdin_failed"), slogError(err))
-	}
+	go func() {
+		if code := runSSHServer(ctx, *sshAddr, hub, *identity, parseAdmins(*admin), authStore, *grace); code != 0 {
+			os.Exit(code)
+		}
+	}()
+
+	go func() {
+		if code := runIRCServer(ctx, *ircAddr, hub, authStore, *grace); code != 0 {
+			os.Exit(code)
+		}
+	}()
+
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			hub.broadcast <- Message{
+				Sender:    0,
+				Kind:      MessageServer,
+				Text:      scanner.Text(),
+				PlainText: fmt.Sprintf("%s %s\n", ServerPrefix, scanner.Text()),
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			slog.Error("failed to read from stdin", slogTag("read_stdin_failed"), slogError(err))
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("shutting down", slogTag("shutdown_start"), slog.Duration("grace", *grace))
+	hub.Notify(fmt.Sprintf("Server shutting down in %.0fs...", grace.Seconds()))
+	time.Sleep(*grace)
+	slog.Info("shutdown complete", slogTag("shutdown_complete"))
 }
 
-func runServer(addr string) int {
+func runServer(ctx context.Context, addr string, hub *Hub, authStore *auth.Auth, grace time.Duration) int {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		slog.Error("failed to create listener", slogTag("listen_failed"), slogError(err))
@@ -62,105 +612,212 @@ func runServer(addr string) int {
 	}
 	slog.Info("server listening", slogTag("listening"), slog.String("addr", addr))
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		<-c
+		<-ctx.Done()
 		l.Close()
 	}()
 
-	nextClientId := 0
 	for {
 		conn, err := l.Accept()
 		if err != nil {
-			slog.Error("failed to accept connection", slogTag("accept_failed"), slogError(err))
-			return 1
+			select {
+			case <-ctx.Done():
+				return 0
+			default:
+				slog.Error("failed to accept connection", slogTag("accept_failed"), slogError(err))
+				return 1
+			}
 		}
-		defer conn.Close()
 
-		nextClientId++
-		client := Client{
-			Id:   nextClientId,
-			Name: fmt.Sprintf("user:%d", nextClientId),
+		if ip, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+			if ban, banned := authStore.Check(auth.ScopeIP, ip); banned {
+				slog.Info("rejected banned ip", slogTag("ip_banned"), slog.String("ip", ip), slog.Any("ban", ban))
+				conn.Close()
+				continue
+			}
+		}
+
+		client := &Client{
+			Id:   newClientId(),
 			Conn: conn,
+			Send: make(chan string, sendBufferSize),
 		}
-		clients[client.Id] = client
-		go handleClient(client)
+		go handleClient(ctx, hub, authStore, client, fmt.Sprintf("user:%d", client.Id), grace)
 	}
 }
 
-func broadcastMessage(msg Message) {
-	slog.Info("message received",
-		slogTag("new_msg"),
-		slog.Int("from", msg.Sender),
-		slog.String("message", string(msg.Message)))
+// watchForShutdown closes conn once ctx is canceled, giving the client up
+// to grace to drain or disconnect on its own first. It returns early,
+// without closing anything, if done is closed first (the client's scanner
+// loop already ended on its own).
+func watchForShutdown(ctx context.Context, done <-chan struct{}, grace time.Duration, conn Conn) {
+	select {
+	case <-ctx.Done():
+	case <-done:
+		return
+	}
+	select {
+	case <-time.After(grace):
+		conn.Close()
+	case <-done:
+	}
+}
 
-	for id, client := range clients {
-		if id != msg.Sender {
-			writeClient(msg.Message, client)
+// clientWriter drains a client's send queue to its connection until the
+// queue is closed by the hub, so a slow reader never blocks doBroadcast.
+func clientWriter(client *Client) {
+	for msg := range client.Send {
+		if _, err := client.Conn.Write([]byte(msg)); err != nil {
+			slog.Error("failed to write to client", slogTag("write_client_failed"), slogError(err), slog.Int("client", client.Id))
 		}
 	}
+	client.Conn.Close()
 }
 
-func handleClient(client Client) {
+func handleClient(ctx context.Context, hub *Hub, authStore *auth.Auth, client *Client, name string, grace time.Duration) {
 	logger := slog.With(slog.Int("client", client.Id))
 	logger.Info("client connected", slogTag("client_connected"))
-	writeClient(fmt.Sprintf(Motd, ServerPrefix, client.Name), client)
 
-	broadcastMessage(Message{
-		Sender:  client.Id,
-		Message: fmt.Sprintf("%s %s joined.\n", ServerPrefix, client.Name),
-	})
+	go clientWriter(client)
+
+	done := make(chan struct{})
+	defer close(done)
+	go watchForShutdown(ctx, done, grace, client.Conn)
+
+	if _, banned := authStore.Check(auth.ScopeNick, name); banned {
+		writeClient(fmt.Sprintf("%s Nickname %s is banned.\n", ServerPrefix, name), client)
+		close(client.Send)
+		return
+	}
+	if forbiddenNickname(client.Id, name) {
+		fallback := fmt.Sprintf("user:%d", client.Id)
+		writeClient(fmt.Sprintf("%s Nickname %s is forbidden; using %s instead.\n", ServerPrefix, name, fallback), client)
+		name = fallback
+	}
+
+	result := make(chan error, 1)
+	hub.register <- registerRequest{client: client, name: name, result: result}
+	if err := <-result; err != nil {
+		fallback := fmt.Sprintf("user:%d", client.Id)
+		writeClient(fmt.Sprintf("%s Nickname %s is already in use; using %s instead.\n", ServerPrefix, name, fallback), client)
+		name = fallback
+		result = make(chan error, 1)
+		hub.register <- registerRequest{client: client, name: name, result: result}
+		if err := <-result; err != nil {
+			logger.Error("failed to register client", slogTag("register_failed"), slogError(err))
+			close(client.Send)
+			return
+		}
+	}
+
+	room := DefaultRoom
+	writeClient(fmt.Sprintf(Motd, ServerPrefix, name, room), client)
+	hub.broadcast <- Message{
+		Sender:     client.Id,
+		SenderName: name,
+		Target:     room,
+		Kind:       MessageJoin,
+		PlainText:  fmt.Sprintf("%s %s joined.\n", ServerPrefix, name),
+	}
 
 	scanner := bufio.NewScanner(client.Conn)
 	for scanner.Scan() {
 		input := scanner.Text()
 		switch {
 		case strings.HasPrefix(input, "/nick"):
-			var newName string
-			if len(input) < 7 {
-				newName = fmt.Sprintf("user:%d", client.Id)
-			} else {
-				newName = input[6:]
-				if strings.HasPrefix(newName, "server") || (strings.HasPrefix(newName, "user:") && newName != fmt.Sprintf("user:%d", client.Id)) {
-					writeClient(fmt.Sprintf("%s Your new nickname, %s, is forbidden.\n", ServerPrefix, newName), client)
-					break
-				}
-			}
-			if _, ok := names[newName]; ok {
-				writeClient(fmt.Sprintf("%s Your new nickname, %s, is currently in use.\n", ServerPrefix, newName), client)
-				break
-			}
-			delete(names, client.Name)
-			names[newName] = struct{}{}
-			broadcastMessage(Message{
-				Sender:  0,
-				Message: fmt.Sprintf("%s %s changed their nickname to %s.\n", ServerPrefix, client.Name, newName),
-			})
-			client.Name = newName
+			name = handleNick(hub, authStore, client, name, input)
+		case strings.HasPrefix(input, "/join "):
+			room = handleJoin(hub, client, room, input)
+		case strings.HasPrefix(input, "/leave"):
+			room = handleLeave(hub, client, room, input)
+		case strings.HasPrefix(input, "/topic "):
+			handleTopic(hub, client, room, input)
+		case strings.HasPrefix(input, "/msg "):
+			handleMsg(hub, client, name, input)
+		case strings.HasPrefix(input, "/me "):
+			handleMe(hub, client, name, room, input)
+		case input == "/who" || strings.HasPrefix(input, "/who "):
+			handleWho(hub, client, room, input)
+		case input == "/list":
+			handleList(hub, client)
+		case strings.HasPrefix(input, "/ban "):
+			handleBan(authStore, client, input)
+		case input == "/banned":
+			handleBanned(authStore, client)
+		case strings.HasPrefix(input, "/unban "):
+			handleUnban(authStore, client, input)
+		case strings.HasPrefix(input, "/kick "):
+			handleKick(hub, client, input)
 		case strings.HasPrefix(input, "/disconnect"):
-			writeClient("Goodbye!", client)
+			writeClient("Goodbye!\n", client)
 			client.Conn.Close()
-			broadcastMessage(Message{
-				Sender:  client.Id,
-				Message: fmt.Sprintf("%s %s disconnected.\n", ServerPrefix, client.Name),
-			})
+			hub.broadcast <- Message{
+				Sender:     client.Id,
+				SenderName: name,
+				Target:     room,
+				Kind:       MessagePart,
+				Text:       "disconnected",
+				PlainText:  fmt.Sprintf("%s %s disconnected.\n", ServerPrefix, name),
+			}
 		default:
-			broadcastMessage(Message{
-				Sender:  client.Id,
-				Message: fmt.Sprintf("%s> %s\n", client.Name, input),
-			})
+			hub.broadcast <- Message{
+				Sender:     client.Id,
+				SenderName: name,
+				Target:     room,
+				Kind:       MessageChat,
+				Text:       input,
+				PlainText:  fmt.Sprintf("%s> %s\n", name, input),
+			}
 		}
 	}
-	delete(clients, client.Id)
+
+	hub.unregister <- unregisterRequest{clientId: client.Id}
 	if err := scanner.Err(); err != nil {
 		logger.Error("failed to read from client", slogTag("read_client_failed"), slogError(err))
 	}
 }
 
-func writeClient(msg string, client Client) {
-	_, err := client.Conn.Write([]byte(msg))
-	if err != nil {
-		slog.Error("failed to write to client", slogTag("write_client_failed"), slogError(err), slog.Int("client", client.Id))
+// forbiddenNickname reports whether name may not be claimed by id: names
+// starting with "server" are reserved, since ServerPrefix ("server!") is how
+// clients recognize a genuine server notice, and "user:<n>" is reserved for
+// id's own auto-generated identity so one client can't impersonate another's.
+func forbiddenNickname(id int, name string) bool {
+	return strings.HasPrefix(name, "server") || (strings.HasPrefix(name, "user:") && name != fmt.Sprintf("user:%d", id))
+}
+
+func handleNick(hub *Hub, authStore *auth.Auth, client *Client, name string, input string) string {
+	var newName string
+	if len(input) < 7 {
+		newName = fmt.Sprintf("user:%d", client.Id)
+	} else {
+		newName = input[6:]
+		if forbiddenNickname(client.Id, newName) {
+			writeClient(fmt.Sprintf("%s Your new nickname, %s, is forbidden.\n", ServerPrefix, newName), client)
+			return name
+		}
+	}
+
+	if _, banned := authStore.Check(auth.ScopeNick, newName); banned {
+		writeClient(fmt.Sprintf("%s Nickname %s is banned.\n", ServerPrefix, newName), client)
+		return name
+	}
+
+	result := make(chan error, 1)
+	hub.rename <- renameRequest{clientId: client.Id, newName: newName, result: result}
+	if err := <-result; err != nil {
+		writeClient(fmt.Sprintf("%s Your new nickname, %s, is currently in use.\n", ServerPrefix, newName), client)
+		return name
+	}
+	return newName
+}
+
+// writeClient enqueues msg on the client's send channel without blocking.
+// If the queue is full the message is dropped and logged; the hub is
+// responsible for eventually disconnecting clients that stay backed up.
+func writeClient(msg string, client *Client) {
+	select {
+	case client.Send <- msg:
+	default:
+		slog.Warn("dropping message, client send queue full", slogTag("write_client_dropped"), slog.Int("client", client.Id))
 	}
 }
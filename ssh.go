@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/sportshead/mediumchat/auth"
+)
+
+// parseAdmins turns the -admin flag value into a lookup set of fingerprints.
+func parseAdmins(flagValue string) map[string]bool {
+	admins := make(map[string]bool)
+	for _, fp := range strings.Split(flagValue, ",") {
+		fp = strings.TrimSpace(fp)
+		if fp != "" {
+			admins[fp] = true
+		}
+	}
+	return admins
+}
+
+// loadOrCreateHostKey reads the SSH host key at path, generating and
+// persisting a fresh ed25519 key on first run.
+func loadOrCreateHostKey(path string) (ssh.Signer, error) {
+	if data, err := os.ReadFile(path); err == nil {
+		return ssh.ParsePrivateKey(data)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading host key: %w", err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating host key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return nil, fmt.Errorf("marshaling host key: %w", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, fmt.Errorf("writing host key: %w", err)
+	}
+	return ssh.NewSignerFromKey(priv)
+}
+
+// runSSHServer listens for SSH connections and drives each session through
+// the same handleClient loop as the raw-TCP listener. A client's public-key
+// fingerprint becomes its stable identity, and its SSH username becomes its
+// initial nickname.
+func runSSHServer(ctx context.Context, addr string, hub *Hub, identityPath string, admins map[string]bool, authStore *auth.Auth, grace time.Duration) int {
+	hostKey, err := loadOrCreateHostKey(identityPath)
+	if err != nil {
+		slog.Error("failed to load ssh host key", slogTag("ssh_identity_failed"), slogError(err))
+		return 1
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			fingerprint := ssh.FingerprintSHA256(key)
+			if ban, banned := authStore.Check(auth.ScopeFingerprint, fingerprint); banned {
+				return nil, fmt.Errorf("banned: %v", ban)
+			}
+			return &ssh.Permissions{
+				Extensions: map[string]string{"fingerprint": fingerprint},
+			}, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("failed to create ssh listener", slogTag("ssh_listen_failed"), slogError(err))
+		return 1
+	}
+	slog.Info("ssh server listening", slogTag("ssh_listening"), slog.String("addr", addr))
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return 0
+			default:
+				slog.Error("failed to accept ssh connection", slogTag("ssh_accept_failed"), slogError(err))
+				return 1
+			}
+		}
+
+		if ip, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+			if ban, banned := authStore.Check(auth.ScopeIP, ip); banned {
+				slog.Info("rejected banned ip", slogTag("ip_banned"), slog.String("ip", ip), slog.Any("ban", ban))
+				conn.Close()
+				continue
+			}
+		}
+		go handleSSHConn(ctx, conn, config, hub, admins, authStore, grace)
+	}
+}
+
+// handleSSHConn completes the SSH handshake, accepts the client's session
+// channel, and hands it off to handleClient just like a raw-TCP connection.
+func handleSSHConn(ctx context.Context, conn net.Conn, config *ssh.ServerConfig, hub *Hub, admins map[string]bool, authStore *auth.Auth, grace time.Duration) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		slog.Error("ssh handshake failed", slogTag("ssh_handshake_failed"), slogError(err))
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			slog.Error("failed to accept ssh channel", slogTag("ssh_channel_failed"), slogError(err))
+			continue
+		}
+		go acceptSSHRequests(requests)
+
+		fingerprint := sshConn.Permissions.Extensions["fingerprint"]
+		client := &Client{
+			Id:          newClientId(),
+			Conn:        channel,
+			Send:        make(chan string, sendBufferSize),
+			Fingerprint: fingerprint,
+			IsAdmin:     admins[fingerprint],
+		}
+		go handleClient(ctx, hub, authStore, client, sshConn.User(), grace)
+	}
+}
+
+// acceptSSHRequests acks pty-req and shell requests so ordinary SSH clients
+// (which expect an interactive session) connect cleanly; everything else is
+// ignored since MediumChat has no real PTY.
+func acceptSSHRequests(requests <-chan *ssh.Request) {
+	for req := range requests {
+		switch req.Type {
+		case "pty-req", "shell":
+			req.Reply(true, nil)
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}